@@ -0,0 +1,41 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/altinity/clickhouse-operator/pkg/chop"
+)
+
+// conflictBackoff returns the operator's configured conflict-retry backoff, falling back to
+// retry.DefaultBackoff when unset, so clusters under heavy churn can widen it without a rebuild.
+func conflictBackoff() wait.Backoff {
+	cfg := chop.Config().Reconcile.Runtime.ConflictBackoff
+	if cfg.Steps == 0 {
+		return retry.DefaultBackoff
+	}
+	return cfg
+}
+
+// withConflictRetry runs mutate, retrying it on 409 Conflict per conflictBackoff. mutate is
+// expected to re-Get the live object, re-apply the desired change on top of it, and Update -
+// RetryOnConflict only retries the call, it doesn't refresh stale state for you.
+func withConflictRetry(_ context.Context, mutate func() error) error {
+	return retry.RetryOnConflict(conflictBackoff(), mutate)
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"context"
+	"time"
+
+	"github.com/altinity/clickhouse-operator/pkg/chop"
+)
+
+// defaultLabelReconcileInterval is how often RunPeriodicReconcile re-scans the cluster for label
+// drift when chop.Config().Reconcile.Runtime.LabelReconcileInterval isn't set.
+const defaultLabelReconcileInterval = 5 * time.Minute
+
+// StartBackgroundReconcilers launches the long-running background loops worker and labeler need
+// beyond the per-CHI reconcile path: worker.Run blocks until ctx is done and then drains any
+// shard goroutines still in flight, and labeler.RunPeriodicReconcile repairs label/annotation
+// drift left behind by an operator upgrade or crash-restart on an ongoing basis. The controller's
+// startup code is expected to call this once, right after constructing its worker pool and
+// Labeler and after the informer factories have synced. ctx must be the controller's
+// process-lifetime context, cancelled once on shutdown - never a per-reconcile context, which
+// would stop both loops after the very first reconcile.
+func StartBackgroundReconcilers(ctx context.Context, w *worker, labeler *Labeler) {
+	interval := chop.Config().Reconcile.Runtime.LabelReconcileInterval
+	if interval <= 0 {
+		interval = defaultLabelReconcileInterval
+	}
+
+	go w.Run(ctx)
+	go labeler.RunPeriodicReconcile(ctx, interval)
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/altinity/clickhouse-operator/pkg/announcer"
+	api "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	"github.com/altinity/clickhouse-operator/pkg/controller/chi/metrics"
+	"github.com/altinity/clickhouse-operator/pkg/util"
+)
+
+// hostRestartRecord is the restart history kept for a single host.
+type hostRestartRecord struct {
+	lastStart             time.Time
+	lastForceRestart      time.Time
+	forceRestartsInWindow []time.Time
+	consecutiveFailures   int
+}
+
+// HostRestartTracker remembers, per host, when it was last (force-)restarted and how many times
+// it has been force-restarted within the configured window, so shouldForceRestartHost can refuse
+// to zero out a StatefulSet that is already flapping.
+type HostRestartTracker struct {
+	mu      sync.Mutex
+	records map[string]*hostRestartRecord
+}
+
+// NewHostRestartTracker creates an empty tracker, one per worker.
+func NewHostRestartTracker() *HostRestartTracker {
+	return &HostRestartTracker{
+		records: make(map[string]*hostRestartRecord),
+	}
+}
+
+func hostRestartKey(host *api.Host) string {
+	return util.NamespaceNameString(host.GetCR()) + "/" + host.GetName()
+}
+
+func (t *HostRestartTracker) record(host *api.Host) *hostRestartRecord {
+	key := hostRestartKey(host)
+	r, ok := t.records[key]
+	if !ok {
+		r = &hostRestartRecord{}
+		t.records[key] = r
+	}
+	return r
+}
+
+// RecordStart marks host as having (re)started now, resetting its consecutive failure count.
+func (t *HostRestartTracker) RecordStart(host *api.Host) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.record(host)
+	r.lastStart = time.Now()
+	r.consecutiveFailures = 0
+}
+
+// RecordFailure increments host's consecutive failure count, feeding the cool-down clause of
+// ShouldThrottle.
+func (t *HostRestartTracker) RecordFailure(host *api.Host) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.record(host).consecutiveFailures++
+}
+
+// RecordForceRestart records that host is about to be force-restarted, pruning window entries
+// older than window and publishing the restart-total/last-restart-timestamp metrics.
+func (t *HostRestartTracker) RecordForceRestart(host *api.Host, window time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	r := t.record(host)
+	r.lastForceRestart = now
+	r.forceRestartsInWindow = append(prune(r.forceRestartsInWindow, now, window), now)
+
+	metrics.HostRestartsTotal(host.GetCR())
+	metrics.HostLastRestartTimestamp(host.GetCR(), now)
+}
+
+// ShouldThrottle reports whether host has been force-restarted too often lately to warrant
+// another force-restart under policy, and the reason, for logging.
+func (t *HostRestartTracker) ShouldThrottle(host *api.Host, policy api.HostRestartPolicy) (bool, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.record(host)
+	now := time.Now()
+
+	if policy.CooldownAfterFailures > 0 && r.consecutiveFailures >= policy.CooldownAfterFailures {
+		return true, "host is in cool-down after repeated reconcile failures"
+	}
+
+	if policy.MinInterval > 0 && !r.lastForceRestart.IsZero() && now.Sub(r.lastForceRestart) < policy.MinInterval {
+		return true, "host was force-restarted too recently"
+	}
+
+	if policy.Window > 0 && policy.MaxRestartsPerWindow > 0 {
+		inWindow := prune(r.forceRestartsInWindow, now, policy.Window)
+		r.forceRestartsInWindow = inWindow
+		if len(inWindow) >= policy.MaxRestartsPerWindow {
+			return true, "host exceeded max force-restarts allowed within the window"
+		}
+	}
+
+	return false, ""
+}
+
+// LastRestartTime returns the last time host was (force-)restarted, for surfacing on HostStatus.
+func (t *HostRestartTracker) LastRestartTime(host *api.Host) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.record(host).lastForceRestart
+}
+
+// RestartCount returns how many force-restarts host has accumulated within the tracked window.
+func (t *HostRestartTracker) RestartCount(host *api.Host) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.record(host).forceRestartsInWindow)
+}
+
+func prune(restarts []time.Time, now time.Time, window time.Duration) []time.Time {
+	kept := restarts[:0]
+	for _, r := range restarts {
+		if now.Sub(r) <= window {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// restartTracker lazily returns the worker's HostRestartTracker, creating it on first use.
+func (w *worker) restartTracker() *HostRestartTracker {
+	if w.hostRestartTracker == nil {
+		w.hostRestartTracker = NewHostRestartTracker()
+	}
+	return w.hostRestartTracker
+}
+
+// shouldForceRestartHost decides whether host's StatefulSet should be scaled to zero and back as
+// part of this reconcile, consulting the restart tracker so a flapping host can't be continually
+// bounced past spec.reconciling.hostRestartPolicy.
+func (w *worker) shouldForceRestartHost(host *api.Host) bool {
+	if !host.GetReconcileAttributes().GetStatus().IsRequireForceRestart() {
+		return false
+	}
+
+	policy := host.GetCR().GetSpec().GetReconciling().GetHostRestartPolicy()
+	if throttle, reason := w.restartTracker().ShouldThrottle(host, policy); throttle {
+		log.V(1).M(host).F().Warning("Force restart of host: %s suppressed: %s", host.GetName(), reason)
+		return false
+	}
+
+	w.restartTracker().RecordForceRestart(host, policy.Window)
+	host.GetCR().EnsureStatus().HostForceRestarted()
+
+	return true
+}
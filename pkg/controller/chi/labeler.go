@@ -16,15 +16,21 @@ package chi
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/altinity/clickhouse-operator/pkg/apis/deployment"
 	"github.com/altinity/clickhouse-operator/pkg/interfaces"
 	"strings"
 
-	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
 
 	log "github.com/altinity/clickhouse-operator/pkg/announcer"
 	api "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
@@ -43,6 +49,10 @@ type Labeler struct {
 	service    interfaces.IKubeService
 	replicaSet interfaces.IKubeReplicaSet
 	deployment interfaces.IKubeDeployment
+	sts        interfaces.IKubeSTS
+	node       interfaces.IKubeNode
+	dynamic    dynamic.Interface
+	restMapper apimeta.RESTMapper
 }
 
 func NewLabeler(kube interfaces.IKube) *Labeler {
@@ -51,15 +61,20 @@ func NewLabeler(kube interfaces.IKube) *Labeler {
 		service:    kube.Service(),
 		replicaSet: kube.ReplicaSet(),
 		deployment: kube.Deployment(),
+		sts:        kube.STS(),
+		node:       kube.Node(),
+		dynamic:    kube.Dynamic(),
+		restMapper: kube.RESTMapper(),
 	}
 }
 
 func (c *Labeler) labelMyObjectsTree(ctx context.Context) error {
 
-	// Operator is running in the Pod. We need to label this Pod
-	// Pod is owned by ReplicaSet. We need to label this ReplicaSet also.
-	// ReplicaSet is owned by Deployment. We need to label this Deployment also.
-	// Deployment is not owned by any entity so far.
+	// Operator is running in the Pod. We need to label this Pod.
+	// The Pod is owned by some workload controller - ReplicaSet, StatefulSet, DaemonSet, an Argo
+	// Rollout, or anything else that sets itself as a controller owner reference - and that
+	// controller may in turn be owned by another one (e.g. ReplicaSet -> Deployment). We walk
+	// that whole chain and label every link, however deep it goes.
 	//
 	// Excerpt from Pod's yaml
 	// metadata:
@@ -110,22 +125,8 @@ func (c *Labeler) labelMyObjectsTree(ctx context.Context) error {
 		return fmt.Errorf("ERROR label pod %s/%s", namespace, name)
 	}
 
-	// Put labels on the ReplicaSet
-	replicaSet, err := c.labelReplicaSet(ctx, pod)
-	if err != nil {
-		return err
-	}
-	if replicaSet == nil {
-		return fmt.Errorf("ERROR label ReplicaSet for pod %s/%s", pod.Namespace, pod.Name)
-	}
-
-	// Put labels on the Deployment
-	err = c.labelDeployment(ctx, replicaSet)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// Walk and label whatever owns the pod, and whatever owns that, all the way up the chain
+	return c.labelOwnerChain(ctx, pod)
 }
 
 func (c *Labeler) labelPod(ctx context.Context, namespace, name string) (*core.Pod, error) {
@@ -140,114 +141,117 @@ func (c *Labeler) labelPod(ctx context.Context, namespace, name string) (*core.P
 		return nil, errors.New(str)
 	}
 
-	// Put label on the Pod
-	pod.Labels = c.addLabels(pod.Labels)
-	pod, err = c.pod.Update(ctx, pod)
+	// Put label on the Pod, patching only the keys that actually changed. addLabels mutates and
+	// returns its argument in place (via util.MergeStringMapsOverwrite), so snapshot the labels
+	// first - passing pod.Labels for both before and after would alias the same map and the patch
+	// would always come out empty.
+	before := copyStringMap(pod.Labels)
+	patch, hasChanges := labelsMergePatch(before, c.addLabels(pod.Labels))
+	if !hasChanges {
+		return pod, nil
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var e error
+		pod, e = c.pod.PatchLabels(ctx, namespace, name, patch)
+		return e
+	})
 	if err != nil {
-		log.V(1).M(namespace, name).F().Error("ERROR put label on Pod %s/%s %v", namespace, name, err)
+		log.V(1).M(namespace, name).F().Error("ERROR patch label on Pod %s/%s %v", namespace, name, err)
 		return nil, err
 	}
-	if pod == nil {
-		str := fmt.Sprintf("ERROR update Pod is nil %s/%s ", namespace, name)
-		log.V(1).M(namespace, name).F().Error(str)
-		return nil, errors.New(str)
-	}
 
 	return pod, nil
 }
 
-func (c *Labeler) labelReplicaSet(ctx context.Context, pod *core.Pod) (*apps.ReplicaSet, error) {
-	// Find parent ReplicaSet
-	replicaSetName := ""
-	for i := range pod.OwnerReferences {
-		owner := &pod.OwnerReferences[i]
-		if owner.Kind == "ReplicaSet" {
-			// ReplicaSet found
-			replicaSetName = owner.Name
-			break
-		}
-	}
-
-	if replicaSetName == "" {
-		// ReplicaSet not found
-		str := fmt.Sprintf("ERROR ReplicaSet for Pod %s/%s not found", pod.Namespace, pod.Name)
-		log.V(1).M(pod.Namespace, pod.Name).F().Error(str)
-		return nil, errors.New(str)
+// labelOwnerChain labels the controller owner of obj, then recurses into that owner's own
+// controller owner, and so on, until it hits an object with no controller owner. It doesn't care
+// what Kind the owner is - ReplicaSet, Deployment, StatefulSet, DaemonSet, a KusionStack
+// CollaSet/OperatingSet, an Argo Rollout, anything - it resolves the owner's GVK via the
+// RESTMapper and fetches/patches it through the dynamic client, so the operator can be shipped
+// under any workload controller without code changes.
+func (c *Labeler) labelOwnerChain(ctx context.Context, obj metav1.Object) error {
+	owner := metav1.GetControllerOfNoCopy(obj)
+	if owner == nil {
+		// Reached the top of the chain - nothing controls this object
+		return nil
 	}
 
-	// ReplicaSet namespaced name found, fetch the ReplicaSet
-	replicaSet, err := c.replicaSet.Get(pod.Namespace, replicaSetName)
+	gvk := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+	mapping, err := c.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
 	if err != nil {
-		log.V(1).M(pod.Namespace, replicaSetName).F().Error("ERROR get ReplicaSet %s/%s %v", pod.Namespace, replicaSetName, err)
-		return nil, err
-	}
-	if replicaSet == nil {
-		str := fmt.Sprintf("ERROR get ReplicaSet is nil %s/%s ", pod.Namespace, replicaSetName)
-		log.V(1).M(pod.Namespace, replicaSetName).F().Error(str)
-		return nil, errors.New(str)
+		str := fmt.Sprintf("ERROR map owner kind %s for %s/%s %v", owner.Kind, obj.GetNamespace(), obj.GetName(), err)
+		log.V(1).M(obj.GetNamespace(), obj.GetName()).F().Error(str)
+		return errors.New(str)
 	}
 
-	// Put label on the ReplicaSet
-	replicaSet.Labels = c.addLabels(replicaSet.Labels)
-	replicaSet, err = c.replicaSet.Update(replicaSet)
+	resource := c.dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+
+	parent, err := resource.Get(ctx, owner.Name, metav1.GetOptions{})
 	if err != nil {
-		log.V(1).M(pod.Namespace, replicaSetName).F().Error("ERROR put label on ReplicaSet %s/%s %v", pod.Namespace, replicaSetName, err)
-		return nil, err
+		log.V(1).M(obj.GetNamespace(), owner.Name).F().Error("ERROR get owner %s %s/%s %v", owner.Kind, obj.GetNamespace(), owner.Name, err)
+		return err
 	}
-	if replicaSet == nil {
-		str := fmt.Sprintf("ERROR update ReplicaSet is nil %s/%s ", pod.Namespace, replicaSetName)
-		log.V(1).M(pod.Namespace, replicaSetName).F().Error(str)
-		return nil, errors.New(str)
+
+	// Put label on the owner, patching only the keys that actually changed. Snapshot the labels
+	// before addLabels mutates them in place, same reasoning as labelPod.
+	before := copyStringMap(parent.GetLabels())
+	patch, hasChanges := labelsMergePatch(before, c.addLabels(parent.GetLabels()))
+	if hasChanges {
+		err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			var e error
+			parent, e = resource.Patch(ctx, owner.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+			return e
+		})
+		if err != nil {
+			log.V(1).M(obj.GetNamespace(), owner.Name).F().Error("ERROR patch label on owner %s %s/%s %v", owner.Kind, obj.GetNamespace(), owner.Name, err)
+			return err
+		}
 	}
 
-	return replicaSet, nil
+	return c.labelOwnerChain(ctx, parent)
 }
 
-func (c *Labeler) labelDeployment(ctx context.Context, rs *apps.ReplicaSet) error {
-	// Find parent Deployment
-	deploymentName := ""
-	for i := range rs.OwnerReferences {
-		owner := &rs.OwnerReferences[i]
-		if owner.Kind == "Deployment" {
-			// Deployment found
-			deploymentName = owner.Name
-			break
-		}
-	}
+// labelsMergePatch builds a minimal JSON-merge patch of metadata.labels, containing only the
+// keys that differ between before and after (with a JSON null for keys that were removed).
+// Applying it via the typed client's Patch method avoids a Get->mutate->Update round trip, which
+// races with kubelet/other controllers and fails with 409 Conflict under load.
+func labelsMergePatch(before, after map[string]string) (patch []byte, hasChanges bool) {
+	return mergePatch("labels", before, after)
+}
 
-	if deploymentName == "" {
-		// Deployment not found
-		str := fmt.Sprintf("ERROR find Deployment for ReplicaSet %s/%s not found", rs.Namespace, rs.Name)
-		log.V(1).M(rs.Namespace, rs.Name).F().Error(str)
-		return errors.New(str)
-	}
+// annotationsMergePatch is the metadata.annotations counterpart of labelsMergePatch.
+func annotationsMergePatch(before, after map[string]string) (patch []byte, hasChanges bool) {
+	return mergePatch("annotations", before, after)
+}
 
-	// Deployment namespaced name found, fetch the Deployment
-	deployment, err := c.deployment.Get(rs.Namespace, deploymentName)
-	if err != nil {
-		log.V(1).M(rs.Namespace, deploymentName).F().Error("ERROR get Deployment %s/%s", rs.Namespace, deploymentName)
-		return err
+func mergePatch(field string, before, after map[string]string) (patch []byte, hasChanges bool) {
+	changes := map[string]interface{}{}
+	for k, v := range after {
+		if before[k] != v {
+			changes[k] = v
+		}
 	}
-	if deployment == nil {
-		str := fmt.Sprintf("ERROR get Deployment is nil %s/%s ", rs.Namespace, deploymentName)
-		log.V(1).M(rs.Namespace, deploymentName).F().Error(str)
-		return errors.New(str)
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			changes[k] = nil
+		}
+	}
+	if len(changes) == 0 {
+		return nil, false
 	}
 
-	// Put label on the Deployment
-	deployment.Labels = c.addLabels(deployment.Labels)
-	deployment, err = c.deployment.Update(deployment)
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			field: changes,
+		},
+	})
 	if err != nil {
-		log.V(1).M(rs.Namespace, deploymentName).F().Error("ERROR put label on Deployment %s/%s %v", rs.Namespace, deploymentName, err)
-		return err
-	}
-	if deployment == nil {
-		str := fmt.Sprintf("ERROR update Deployment is nil %s/%s ", rs.Namespace, deploymentName)
-		log.V(1).M(rs.Namespace, deploymentName).F().Error(str)
-		return errors.New(str)
+		log.V(1).F().Error("ERROR build %s merge patch %v", field, err)
+		return nil, false
 	}
 
-	return nil
+	return patch, true
 }
 
 // addLabels adds app and version labels
@@ -264,6 +268,116 @@ func (c *Labeler) addLabels(labels map[string]string) map[string]string {
 	)
 }
 
+// Well-known Kubernetes topology labels, propagated from a host's Node so that ClickHouse
+// remote_servers shard assignments and pod-topology-spread constraints can be written purely as
+// LabelSelectors against operator-managed pods, without a second controller watching Nodes.
+const (
+	labelTopologyZone   = "topology.kubernetes.io/zone"
+	labelTopologyRegion = "topology.kubernetes.io/region"
+	labelKubernetesHost = "kubernetes.io/hostname"
+)
+
+// topologyLabels resolves the Node backing nodeName and returns the zone/region/hostname labels
+// plus whatever extra Node labels the user asked to propagate via
+// chop.Config().Labels.PropagateFromNode. Returns an empty map if nodeName is empty or the Node
+// can't be fetched - topology awareness is a nice-to-have, not a precondition for labeling.
+func (c *Labeler) topologyLabels(nodeName string) map[string]string {
+	if nodeName == "" {
+		return nil
+	}
+
+	node, err := c.node.Get(nodeName)
+	if err != nil {
+		log.V(1).M(nodeName).F().Warning("ERROR get Node %s for topology labels %v", nodeName, err)
+		return nil
+	}
+
+	topology := map[string]string{
+		labelKubernetesHost: nodeName,
+	}
+	if zone, ok := node.Labels[labelTopologyZone]; ok {
+		topology[labelTopologyZone] = zone
+	}
+	if region, ok := node.Labels[labelTopologyRegion]; ok {
+		topology[labelTopologyRegion] = region
+	}
+	for _, key := range chop.Config().Labels.PropagateFromNode {
+		if value, ok := node.Labels[key]; ok {
+			topology[key] = value
+		}
+	}
+
+	return topology
+}
+
+// labelPodTopology stamps the host's Pod with its Node's topology labels, patching only the keys
+// that actually changed.
+func (c *Labeler) labelPodTopology(ctx context.Context, host *api.Host) error {
+	if util.IsContextDone(ctx) {
+		log.V(2).Info("task is done")
+		return nil
+	}
+
+	pod, err := c.pod.Get(host)
+	if err != nil {
+		log.M(host).F().Error("FAIL get pod for host %s err:%v", host.Runtime.Address.NamespaceNameString(), err)
+		return err
+	}
+
+	topology := c.topologyLabels(pod.Spec.NodeName)
+	if len(topology) == 0 {
+		return nil
+	}
+
+	before := copyStringMap(pod.Labels)
+	patch, hasChanges := labelsMergePatch(before, util.MergeStringMapsOverwrite(pod.Labels, topology))
+	if !hasChanges {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, e := c.pod.PatchLabels(ctx, pod.Namespace, pod.Name, patch)
+		return e
+	})
+}
+
+// labelServiceTopology stamps the host's Service with the same topology labels as its Pod, so
+// Services can be selected on zone/region/hostname too.
+func (c *Labeler) labelServiceTopology(ctx context.Context, host *api.Host) error {
+	if util.IsContextDone(ctx) {
+		log.V(2).Info("task is done")
+		return nil
+	}
+
+	pod, err := c.pod.Get(host)
+	if err != nil {
+		log.M(host).F().Error("FAIL get pod for host %s err:%v", host.Runtime.Address.NamespaceNameString(), err)
+		return err
+	}
+
+	topology := c.topologyLabels(pod.Spec.NodeName)
+	if len(topology) == 0 {
+		return nil
+	}
+
+	svc, err := c.service.Get(host)
+	if err != nil {
+		log.M(host).F().Error("FAIL get service for host %s err:%v", host.Runtime.Address.NamespaceNameString(), err)
+		return err
+	}
+
+	before := copyStringMap(svc.Labels)
+	patch, hasChanges := labelsMergePatch(before, util.MergeStringMapsOverwrite(svc.Labels, topology))
+	if !hasChanges {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, e := c.service.PatchLabels(svc.Namespace, svc.Name, patch)
+		return e
+	})
+}
+
 // appendLabelReadyOnPod appends Label "Ready" to the pod of the specified host
 func (c *Labeler) appendLabelReadyOnPod(ctx context.Context, host *api.Host) error {
 	if util.IsContextDone(ctx) {
@@ -277,13 +391,23 @@ func (c *Labeler) appendLabelReadyOnPod(ctx context.Context, host *api.Host) err
 		return err
 	}
 
-	if commonLabeler.AppendLabelReady(&pod.ObjectMeta) {
-		// Modified, need to update
-		_, err = c.pod.Update(ctx, pod)
-		if err != nil {
-			log.M(host).F().Error("FAIL setting 'ready' label for host %s err:%v", host.Runtime.Address.NamespaceNameString(), err)
-			return err
-		}
+	before := copyStringMap(pod.Labels)
+	if !commonLabeler.AppendLabelReady(&pod.ObjectMeta) {
+		return nil
+	}
+
+	patch, hasChanges := labelsMergePatch(before, pod.Labels)
+	if !hasChanges {
+		return nil
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, e := c.pod.PatchLabels(ctx, pod.Namespace, pod.Name, patch)
+		return e
+	})
+	if err != nil {
+		log.M(host).F().Error("FAIL setting 'ready' label for host %s err:%v", host.Runtime.Address.NamespaceNameString(), err)
+		return err
 	}
 
 	return nil
@@ -311,13 +435,20 @@ func (c *Labeler) deleteLabelReadyOnPod(ctx context.Context, host *api.Host) err
 		return err
 	}
 
-	if commonLabeler.DeleteLabelReady(&pod.ObjectMeta) {
-		// Modified, need to update
-		_, err = c.pod.Update(ctx, pod)
-		return err
+	before := copyStringMap(pod.Labels)
+	if !commonLabeler.DeleteLabelReady(&pod.ObjectMeta) {
+		return nil
 	}
 
-	return nil
+	patch, hasChanges := labelsMergePatch(before, pod.Labels)
+	if !hasChanges {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, e := c.pod.PatchLabels(ctx, pod.Namespace, pod.Name, patch)
+		return e
+	})
 }
 
 // appendAnnotationReadyOnService appends Annotation "Ready" to the service of the specified host
@@ -333,13 +464,23 @@ func (c *Labeler) appendAnnotationReadyOnService(ctx context.Context, host *api.
 		return err
 	}
 
-	if commonLabeler.AppendAnnotationReady(&svc.ObjectMeta) {
-		// Modified, need to update
-		_, err = c.service.Update(svc)
-		if err != nil {
-			log.M(host).F().Error("FAIL setting 'ready' annotation for host service %s err:%v", host.Runtime.Address.NamespaceNameString(), err)
-			return err
-		}
+	before := copyStringMap(svc.Annotations)
+	if !commonLabeler.AppendAnnotationReady(&svc.ObjectMeta) {
+		return nil
+	}
+
+	patch, hasChanges := annotationsMergePatch(before, svc.Annotations)
+	if !hasChanges {
+		return nil
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, e := c.service.PatchAnnotations(svc.Namespace, svc.Name, patch)
+		return e
+	})
+	if err != nil {
+		log.M(host).F().Error("FAIL setting 'ready' annotation for host service %s err:%v", host.Runtime.Address.NamespaceNameString(), err)
+		return err
 	}
 
 	return nil
@@ -367,11 +508,28 @@ func (c *Labeler) deleteAnnotationReadyOnService(ctx context.Context, host *api.
 		return err
 	}
 
-	if commonLabeler.DeleteAnnotationReady(&svc.ObjectMeta) {
-		// Modified, need to update
-		_, err = c.service.Update(svc)
-		return err
+	before := copyStringMap(svc.Annotations)
+	if !commonLabeler.DeleteAnnotationReady(&svc.ObjectMeta) {
+		return nil
 	}
 
-	return nil
+	patch, hasChanges := annotationsMergePatch(before, svc.Annotations)
+	if !hasChanges {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, e := c.service.PatchAnnotations(svc.Namespace, svc.Name, patch)
+		return e
+	})
+}
+
+// copyStringMap returns a shallow copy of m, so the caller can diff pre/post mutation state
+// without the mutation itself clobbering the "before" snapshot.
+func copyStringMap(m map[string]string) map[string]string {
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
 }
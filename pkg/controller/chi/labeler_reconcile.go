@@ -0,0 +1,301 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+	"strings"
+	"time"
+
+	log "github.com/altinity/clickhouse-operator/pkg/announcer"
+	"github.com/altinity/clickhouse-operator/pkg/chop"
+	"github.com/altinity/clickhouse-operator/pkg/controller/chi/metrics"
+	commonLabeler "github.com/altinity/clickhouse-operator/pkg/model/common/tags/labeler"
+	"github.com/altinity/clickhouse-operator/pkg/util"
+
+	"context"
+)
+
+// operatorLabelSelector builds the LabelSelector matching all objects this (or any prior)
+// operator instance has stamped with its app label, regardless of which version stamped them.
+func operatorLabelSelector() (labels.Selector, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels: labels.Set{
+			commonLabeler.LabelAppName: commonLabeler.LabelAppValue,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return selector, nil
+}
+
+// isStaleVersion returns true when labels carry chop/chop-commit/chop-date values that do not
+// match the operator binary currently running this code.
+func (c *Labeler) isStaleVersion(objLabels map[string]string) bool {
+	if objLabels == nil {
+		return true
+	}
+	return objLabels[commonLabeler.LabelCHOP] != chop.Get().Version ||
+		objLabels[commonLabeler.LabelCHOPCommit] != chop.Get().Commit ||
+		objLabels[commonLabeler.LabelCHOPDate] != strings.ReplaceAll(chop.Get().Date, ":", ".")
+}
+
+// ReconcileOperatorLabels lists all Pods, ReplicaSets and Deployments carrying the operator's
+// app label across the whole cluster and repairs drift left behind by an operator upgrade or
+// crash-restart: objects stamped with a chop/chop-commit/chop-date that no longer matches the
+// running operator get re-labeled, and "Ready" labels/annotations orphaned by a host whose
+// StatefulSet no longer exists get cleaned up. Unlike labelMyObjectsTree, which only walks the
+// operator's own single owner chain once at startup, this is meant to be invoked periodically
+// from the controller loop so downstream ClickHouse pods/services don't keep stale metadata
+// forever.
+func (c *Labeler) ReconcileOperatorLabels(ctx context.Context) error {
+	if util.IsContextDone(ctx) {
+		log.V(2).Info("task is done")
+		return nil
+	}
+
+	selector, err := operatorLabelSelector()
+	if err != nil {
+		log.V(1).F().Error("ERROR build operator label selector %v", err)
+		return err
+	}
+
+	if err := c.reconcilePodLabels(ctx, selector); err != nil {
+		return err
+	}
+	if err := c.reconcileReplicaSetLabels(ctx, selector); err != nil {
+		return err
+	}
+	if err := c.reconcileDeploymentLabels(ctx, selector); err != nil {
+		return err
+	}
+	if err := c.reconcileServiceLabels(ctx, selector); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RunPeriodicReconcile calls ReconcileOperatorLabels once every interval until ctx is done. The
+// controller's startup path is expected to launch this in its own goroutine alongside the
+// informer factories, so label/annotation drift left behind by an operator upgrade or
+// crash-restart gets repaired on an ongoing basis rather than only at operator startup.
+func (c *Labeler) RunPeriodicReconcile(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ReconcileOperatorLabels(ctx); err != nil {
+				log.V(1).F().Error("ERROR periodic ReconcileOperatorLabels %v", err)
+			}
+		}
+	}
+}
+
+func (c *Labeler) reconcilePodLabels(ctx context.Context, selector labels.Selector) error {
+	pods, err := c.pod.List(ctx, selector)
+	if err != nil {
+		log.V(1).F().Error("ERROR list Pods by selector %s %v", selector.String(), err)
+		return err
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		before := copyStringMap(pod.Labels)
+
+		if c.isStaleVersion(pod.Labels) {
+			metrics.LabelDriftDetected(ctx, "pod")
+			pod.Labels = c.addLabels(pod.Labels)
+		}
+
+		if commonLabeler.HasLabelReady(&pod.ObjectMeta) && c.ownerStatefulSetGone(pod.Namespace, pod.OwnerReferences) {
+			metrics.LabelDriftDetected(ctx, "pod-ready")
+			commonLabeler.DeleteLabelReady(&pod.ObjectMeta)
+		}
+
+		patch, hasChanges := labelsMergePatch(before, pod.Labels)
+		if !hasChanges {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			_, e := c.pod.PatchLabels(ctx, pod.Namespace, pod.Name, patch)
+			return e
+		})
+		if err != nil {
+			log.V(1).M(pod.Namespace, pod.Name).F().Error("ERROR repair labels on Pod %s/%s %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		metrics.LabelDriftRepaired(ctx, "pod")
+	}
+
+	return nil
+}
+
+func (c *Labeler) reconcileReplicaSetLabels(ctx context.Context, selector labels.Selector) error {
+	replicaSets, err := c.replicaSet.List(ctx, selector)
+	if err != nil {
+		log.V(1).F().Error("ERROR list ReplicaSets by selector %s %v", selector.String(), err)
+		return err
+	}
+
+	for i := range replicaSets {
+		rs := &replicaSets[i]
+		if !c.isStaleVersion(rs.Labels) {
+			continue
+		}
+
+		metrics.LabelDriftDetected(ctx, "replicaset")
+		before := copyStringMap(rs.Labels)
+		patch, hasChanges := labelsMergePatch(before, c.addLabels(rs.Labels))
+		if !hasChanges {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			_, e := c.replicaSet.PatchLabels(rs.Namespace, rs.Name, patch)
+			return e
+		})
+		if err != nil {
+			log.V(1).M(rs.Namespace, rs.Name).F().Error("ERROR repair stale labels on ReplicaSet %s/%s %v", rs.Namespace, rs.Name, err)
+			continue
+		}
+		metrics.LabelDriftRepaired(ctx, "replicaset")
+	}
+
+	return nil
+}
+
+func (c *Labeler) reconcileDeploymentLabels(ctx context.Context, selector labels.Selector) error {
+	deployments, err := c.deployment.List(ctx, selector)
+	if err != nil {
+		log.V(1).F().Error("ERROR list Deployments by selector %s %v", selector.String(), err)
+		return err
+	}
+
+	for i := range deployments {
+		deploy := &deployments[i]
+		if !c.isStaleVersion(deploy.Labels) {
+			continue
+		}
+
+		metrics.LabelDriftDetected(ctx, "deployment")
+		before := copyStringMap(deploy.Labels)
+		patch, hasChanges := labelsMergePatch(before, c.addLabels(deploy.Labels))
+		if !hasChanges {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			_, e := c.deployment.PatchLabels(deploy.Namespace, deploy.Name, patch)
+			return e
+		})
+		if err != nil {
+			log.V(1).M(deploy.Namespace, deploy.Name).F().Error("ERROR repair stale labels on Deployment %s/%s %v", deploy.Namespace, deploy.Name, err)
+			continue
+		}
+		metrics.LabelDriftRepaired(ctx, "deployment")
+	}
+
+	return nil
+}
+
+// reconcileServiceLabels is reconcilePodLabels' counterpart for Services: it repairs stale
+// chop/chop-commit/chop-date labels and cleans up a "Ready" annotation orphaned by a host whose
+// StatefulSet no longer exists.
+func (c *Labeler) reconcileServiceLabels(ctx context.Context, selector labels.Selector) error {
+	services, err := c.service.List(ctx, selector)
+	if err != nil {
+		log.V(1).F().Error("ERROR list Services by selector %s %v", selector.String(), err)
+		return err
+	}
+
+	for i := range services {
+		svc := &services[i]
+		beforeLabels := copyStringMap(svc.Labels)
+		beforeAnnotations := copyStringMap(svc.Annotations)
+
+		if c.isStaleVersion(svc.Labels) {
+			metrics.LabelDriftDetected(ctx, "service")
+			svc.Labels = c.addLabels(svc.Labels)
+		}
+
+		if commonLabeler.HasAnnotationReady(&svc.ObjectMeta) && c.ownerStatefulSetGone(svc.Namespace, svc.OwnerReferences) {
+			metrics.LabelDriftDetected(ctx, "service-ready")
+			commonLabeler.DeleteAnnotationReady(&svc.ObjectMeta)
+		}
+
+		if labelPatch, hasChanges := labelsMergePatch(beforeLabels, svc.Labels); hasChanges {
+			err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+				_, e := c.service.PatchLabels(svc.Namespace, svc.Name, labelPatch)
+				return e
+			})
+			if err != nil {
+				log.V(1).M(svc.Namespace, svc.Name).F().Error("ERROR repair labels on Service %s/%s %v", svc.Namespace, svc.Name, err)
+			} else {
+				metrics.LabelDriftRepaired(ctx, "service")
+			}
+		}
+
+		annotationPatch, hasChanges := annotationsMergePatch(beforeAnnotations, svc.Annotations)
+		if !hasChanges {
+			continue
+		}
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			_, e := c.service.PatchAnnotations(svc.Namespace, svc.Name, annotationPatch)
+			return e
+		})
+		if err != nil {
+			log.V(1).M(svc.Namespace, svc.Name).F().Error("ERROR repair orphaned Ready annotation on Service %s/%s %v", svc.Namespace, svc.Name, err)
+			continue
+		}
+		metrics.LabelDriftRepaired(ctx, "service-ready")
+	}
+
+	return nil
+}
+
+// ownerStatefulSetGone reports whether the Pod's controlling StatefulSet (the CHI host object)
+// has already been deleted, which is how a "Ready" label can be orphaned on a pod the operator
+// is about to garbage-collect anyway. Only a confirmed NotFound counts as "gone" - a transient
+// error (timeout, RBAC, throttling) tells us nothing about the StatefulSet's actual existence, so
+// treating it as "gone" would strip the Ready label off what may be a perfectly healthy pod.
+func (c *Labeler) ownerStatefulSetGone(namespace string, owners []metav1.OwnerReference) bool {
+	for i := range owners {
+		owner := &owners[i]
+		if owner.Kind != "StatefulSet" {
+			continue
+		}
+		_, err := c.sts.Get(namespace, owner.Name)
+		if err == nil {
+			return false
+		}
+		if apiErrors.IsNotFound(err) {
+			return true
+		}
+		log.V(1).M(namespace, owner.Name).F().Warning("ERROR get owner StatefulSet %s/%s %v", namespace, owner.Name, err)
+		return false
+	}
+	// No StatefulSet owner at all - not something we manage, leave it alone
+	return false
+}
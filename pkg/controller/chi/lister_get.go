@@ -0,0 +1,72 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"context"
+
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+
+	"github.com/altinity/clickhouse-operator/pkg/controller"
+)
+
+// getConfigMapCached reads namespace/name through the ConfigMap lister before falling back to a
+// live, retry-wrapped Get. A CHI with dozens of shards/replicas reconciles dozens of ConfigMaps
+// per pass, and most of them haven't changed - the shared informer cache turns that into zero
+// apiserver round trips instead of one Get apiece. A lister miss can mean "genuinely doesn't
+// exist" or "exists but the cache hasn't caught up yet (e.g. right after a Create)", so it always
+// falls through to a live Get rather than being trusted as a definitive NotFound; that live Get
+// rides through retryableKube so a transient apiserver hiccup doesn't fail the whole reconcile.
+func (w *worker) getConfigMapCached(ctx context.Context, namespace, name string) (*core.ConfigMap, error) {
+	if cm, err := w.c.kube.ConfigMap().Lister().ConfigMaps(namespace).Get(name); err == nil {
+		return cm, nil
+	}
+	return w.retryableKube(ctx).ConfigMap().Get(namespace, name)
+}
+
+// getServiceCached is getConfigMapCached's counterpart for Services.
+func (w *worker) getServiceCached(ctx context.Context, namespace, name string) (*core.Service, error) {
+	if svc, err := w.c.kube.Service().Lister().Services(namespace).Get(name); err == nil {
+		return svc, nil
+	}
+	return w.retryableKube(ctx).Service().Get(namespace, name)
+}
+
+// getSecretCached is getConfigMapCached's counterpart for Secrets. Secrets aren't part of
+// retryableKube (STS/ConfigMap/Service/PDB only), so the live fallback goes straight to the
+// client as before.
+func (w *worker) getSecretCached(ctx context.Context, namespace, name string) (*core.Secret, error) {
+	if secret, err := w.c.kube.Secret().Lister().Secrets(namespace).Get(name); err == nil {
+		return secret, nil
+	}
+	return w.c.kubeClient.CoreV1().Secrets(namespace).Get(ctx, name, controller.NewGetOptions())
+}
+
+// getPDBCached is getConfigMapCached's counterpart for PodDisruptionBudgets.
+func (w *worker) getPDBCached(ctx context.Context, namespace, name string) (*policy.PodDisruptionBudget, error) {
+	if pdb, err := w.c.kube.PDB().Lister().PodDisruptionBudgets(namespace).Get(name); err == nil {
+		return pdb, nil
+	}
+	return w.retryableKube(ctx).PDB().Get(namespace, name)
+}
+
+// waitForCachesSynced blocks until every informer backing the lister-first Get helpers above has
+// completed its initial list, so a worker started right after the informer factory never serves
+// a false "not found" off an empty cache. It is called once per reconcile from reconcileCHI;
+// HasSynced is idempotent and cheap once synced, so repeated calls cost nothing.
+func (w *worker) waitForCachesSynced(ctx context.Context) bool {
+	return w.c.kube.WaitForCacheSync(ctx)
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	log "github.com/altinity/clickhouse-operator/pkg/announcer"
+	api "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	"github.com/altinity/clickhouse-operator/pkg/controller/common/retryable"
+)
+
+// sqlExecutor is the subset of a ClickHouse schemer capable of running arbitrary statements,
+// needed by post-host-ready hooks but not by the version probe in retryable.Schemer.
+type sqlExecutor interface {
+	HostExec(ctx context.Context, host *api.Host, sql string) error
+}
+
+// hookSQLHash hashes a hook's SQL body so runOnce hooks re-run automatically when it changes.
+func hookSQLHash(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// hookStatusKey is how a hook's execution is keyed in CHI.Status.Hooks: the same hook name run at
+// two different scope targets (say, two shards) must be tracked independently.
+func hookStatusKey(name, scope, target string) string {
+	return name + "/" + scope + "/" + target
+}
+
+// hookScopeTarget resolves which CHI.Status.Hooks entry a scope: host|shard|cluster|chi hook
+// belongs to from host's position, and returns "" when host isn't the representative host for
+// that scope (e.g. a shard-scoped hook only runs once, from the shard's first host).
+func hookScopeTarget(host *api.Host, scope string) string {
+	switch scope {
+	case "shard":
+		if host.Runtime.Address.ReplicaIndex != 0 {
+			return ""
+		}
+		return host.Runtime.Address.ClusterName + "/" + host.Runtime.Address.ShardName
+	case "cluster":
+		if host.Runtime.Address.ShardIndex != 0 || host.Runtime.Address.ReplicaIndex != 0 {
+			return ""
+		}
+		return host.Runtime.Address.ClusterName
+	case "chi":
+		if !host.IsFirst() {
+			return ""
+		}
+		return host.GetCR().Name
+	default:
+		// "host", or anything we don't recognize - run per host.
+		return host.GetName()
+	}
+}
+
+// runPostHostReadyHooks executes spec.hooks.postHostReady once a host has been confirmed
+// reachable: runOnce hooks are skipped once CHI.Status.Hooks shows they already ran with the same
+// SQL hash, and re-run automatically the first reconcile after their SQL body changes.
+func (w *worker) runPostHostReadyHooks(ctx context.Context, host *api.Host) error {
+	hooks := host.GetCR().GetSpec().GetHooks().GetPostHostReady()
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	for _, hook := range hooks {
+		target := hookScopeTarget(host, hook.Scope)
+		if target == "" {
+			// Not the representative host for this hook's scope - skip, another host owns it.
+			continue
+		}
+
+		key := hookStatusKey(hook.Name, hook.Scope, target)
+		hash := hookSQLHash(hook.SQL)
+
+		if hook.RunOnce {
+			if status, ok := host.GetCR().EnsureStatus().GetHookStatus(key); ok && status.SHA == hash {
+				continue
+			}
+		}
+
+		sql, err := w.resolveHookSQL(ctx, host, hook)
+		if err != nil {
+			return fmt.Errorf("hook %q: resolving secretRef: %w", hook.Name, err)
+		}
+
+		if err := w.execHookSQL(ctx, host, sql); err != nil {
+			return fmt.Errorf("hook %q: %w", hook.Name, err)
+		}
+
+		host.GetCR().EnsureStatus().SetHookStatus(key, api.HookStatus{
+			SHA:        hash,
+			ExecutedAt: time.Now(),
+		})
+		log.V(1).M(host).F().Info("post-host-ready hook %q executed on host: %s (scope: %s/%s)", hook.Name, host.GetName(), hook.Scope, target)
+	}
+
+	return nil
+}
+
+// resolveHookSQL returns hook.SQL. When hook.SecretRef is set it fetches that Secret first, so a
+// missing secretRef fails the hook; the Secret's values are not substituted into the SQL, which
+// is returned unchanged.
+func (w *worker) resolveHookSQL(ctx context.Context, host *api.Host, hook api.PostHostReadyHook) (string, error) {
+	if hook.SecretRef == "" {
+		return hook.SQL, nil
+	}
+	if _, err := w.getSecretCached(ctx, host.GetCR().Namespace, hook.SecretRef); err != nil {
+		return "", err
+	}
+	return hook.SQL, nil
+}
+
+// execHookSQL runs sql on host, retrying transient ClickHouse errors the same way
+// getHostClickHouseVersion does.
+func (w *worker) execHookSQL(ctx context.Context, host *api.Host, sql string) error {
+	executor, ok := w.ensureClusterSchemer(host).(sqlExecutor)
+	if !ok {
+		return fmt.Errorf("schemer for host %s does not support executing arbitrary SQL", host.GetName())
+	}
+	return retryable.Do(ctx, retryPolicyFromConfig(), retryable.DefaultClassify, "exec", "post-host-ready-hook", func() error {
+		return executor.HostExec(ctx, host, sql)
+	})
+}
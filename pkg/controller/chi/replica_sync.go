@@ -0,0 +1,134 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	api "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+	"github.com/altinity/clickhouse-operator/pkg/chop"
+	"github.com/altinity/clickhouse-operator/pkg/controller/chi/metrics"
+)
+
+// Defaults for chop.Config().Reconcile.Runtime.ReplicaSync, used whenever a field is left zero.
+const (
+	defaultReplicaSyncMaxAbsoluteDelaySeconds = int64(30)
+	defaultReplicaSyncMaxQueueSize            = int64(100)
+	defaultReplicaSyncMaxLogLag               = int64(1000)
+	defaultReplicaSyncTimeout                 = 5 * time.Minute
+	defaultReplicaSyncPollInterval            = 5 * time.Second
+)
+
+// maxConsecutiveReplicaSyncQueryErrors bounds how many times in a row HostReplicaSyncState may
+// fail before waitHostInSync gives up early instead of swallowing every error until the full
+// timeout - a host that simply isn't answering queries yet (still starting, crashlooping,
+// network partition) would otherwise block every freshly added host for the whole default
+// 5-minute timeout before its actual replication lag is ever checked.
+const maxConsecutiveReplicaSyncQueryErrors = 3
+
+// replicaSyncState is one sample of system.replicas lag, maxed across a host's replicated tables.
+type replicaSyncState struct {
+	absoluteDelay int64
+	queueSize     int64
+	logLag        int64
+}
+
+func (s replicaSyncState) withinThresholds(cfg api.ReplicaSyncPolicy) bool {
+	maxDelay := cfg.MaxAbsoluteDelaySeconds
+	if maxDelay == 0 {
+		maxDelay = defaultReplicaSyncMaxAbsoluteDelaySeconds
+	}
+	maxQueue := cfg.MaxQueueSize
+	if maxQueue == 0 {
+		maxQueue = defaultReplicaSyncMaxQueueSize
+	}
+	maxLag := cfg.MaxLogLag
+	if maxLag == 0 {
+		maxLag = defaultReplicaSyncMaxLogLag
+	}
+	return s.absoluteDelay <= maxDelay && s.queueSize <= maxQueue && s.logLag <= maxLag
+}
+
+// replicaSyncQuerier is the subset of a ClickHouse schemer that can read replication lag off
+// system.replicas. Kept separate from sqlExecutor (post-host-ready hooks) since this is a
+// narrower, read-only capability.
+type replicaSyncQuerier interface {
+	// HostReplicaSyncState runs
+	// SELECT max(absolute_delay), max(queue_size), max(log_max_index - log_pointer) FROM system.replicas
+	// on host and returns the three maxima.
+	HostReplicaSyncState(ctx context.Context, host *api.Host) (absoluteDelay, queueSize, logLag int64, err error)
+}
+
+// waitHostInSync polls system.replicas until host's replication lag is within the configured (or
+// default) thresholds, or the configured timeout elapses. On timeout it logs a warning and either
+// continues or fails the reconcile, per ReplicaSync.FailOnTimeout - so a cluster that's
+// persistently behind on replication doesn't wedge every reconcile forever by default.
+func (w *worker) waitHostInSync(ctx context.Context, host *api.Host) error {
+	querier, ok := w.ensureClusterSchemer(host).(replicaSyncQuerier)
+	if !ok {
+		// Schemer doesn't support the probe - nothing to gate on.
+		return nil
+	}
+
+	cfg := chop.Config().Reconcile.Runtime.ReplicaSync
+
+	timeout := defaultReplicaSyncTimeout
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout
+	}
+	interval := defaultReplicaSyncPollInterval
+	if cfg.PollInterval > 0 {
+		interval = cfg.PollInterval
+	}
+
+	var last replicaSyncState
+	var consecutiveQueryErrs int
+	pollErr := wait.PollUntilContextTimeout(ctx, interval, timeout, true, func(ctx context.Context) (bool, error) {
+		delay, queue, lag, err := querier.HostReplicaSyncState(ctx, host)
+		if err != nil {
+			consecutiveQueryErrs++
+			if consecutiveQueryErrs >= maxConsecutiveReplicaSyncQueryErrors {
+				// The host isn't answering replica sync queries at all - waiting out the rest of
+				// timeout won't change that, so stop now instead of blocking this host (and every
+				// other host reconcile queued behind it) for the full default 5 minutes.
+				return false, fmt.Errorf("host %s is not answering replica sync queries: %w", host.GetName(), err)
+			}
+			// A single failed query is likely transient (brief connection hiccup) - keep polling.
+			return false, nil
+		}
+		consecutiveQueryErrs = 0
+		last = replicaSyncState{absoluteDelay: delay, queueSize: queue, logLag: lag}
+		metrics.HostReplicaSyncState(host.GetCR(), host.GetName(), delay, queue, lag)
+		return last.withinThresholds(cfg), nil
+	})
+
+	if pollErr == nil {
+		return nil
+	}
+
+	w.a.V(1).M(host).F().Warning(
+		"host: %s did not catch up on replication within %s (absolute_delay=%ds queue_size=%d log_lag=%d): %v",
+		host.GetName(), timeout, last.absoluteDelay, last.queueSize, last.logLag, pollErr,
+	)
+
+	if cfg.FailOnTimeout {
+		return fmt.Errorf("host %s did not catch up on replication within %s: %w", host.GetName(), timeout, pollErr)
+	}
+	return nil
+}
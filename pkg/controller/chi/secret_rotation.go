@@ -0,0 +1,210 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	core "k8s.io/api/core/v1"
+
+	log "github.com/altinity/clickhouse-operator/pkg/announcer"
+	api "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+)
+
+// secretDataHashAnnotation stores the content hash this operator last wrote into a Secret, so
+// reconcileSecret can tell "data actually changed" apart from "object was re-applied verbatim".
+const secretDataHashAnnotation = "clickhouse.altinity.com/secret-data-hash"
+
+// secretRotationPolicyAnnotationPrefix, appended with the Secret's name, is looked up on the CHI
+// to decide how a host should react to that Secret's data changing underneath it.
+const secretRotationPolicyAnnotationPrefix = "clickhouse.altinity.com/secret-rotation/"
+
+// secretPodHashAnnotationPrefix, appended with the Secret's name, is the annotation stamped onto
+// a host's StatefulSet pod template so a changed Secret triggers a rolling pod replacement.
+const secretPodHashAnnotationPrefix = "clickhouse.altinity.com/secret-hash/"
+
+// Rotation policies a CHI can request per Secret via secretRotationPolicyAnnotationPrefix+name.
+const (
+	// SecretRotationRestart rolls the affected hosts' StatefulSet pods (the default).
+	SecretRotationRestart = "restart"
+	// SecretRotationReload leaves the pods running and relies on SYSTEM RELOAD CONFIG picking up
+	// the new Secret content once it lands on disk (TLS certs, interserver credentials).
+	SecretRotationReload = "reload"
+	// SecretRotationNone does nothing beyond updating the Secret itself.
+	SecretRotationNone = "none"
+)
+
+// secretDataHash computes a stable hash over secret's Data (sorted by key, so map iteration order
+// never affects it) and Type, so an identical Secret always hashes the same regardless of how it
+// was rebuilt by the creator.
+func secretDataHash(secret *core.Secret) string {
+	keys := make([]string, 0, len(secret.Data))
+	for k := range secret.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	h.Write([]byte(secret.Type))
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write(secret.Data[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// stampSecretDataHash writes secret's content hash into secretDataHashAnnotation, creating the
+// annotation map if needed, and returns the hash for the caller to compare against.
+func stampSecretDataHash(secret *core.Secret) string {
+	hash := secretDataHash(secret)
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[secretDataHashAnnotation] = hash
+	return hash
+}
+
+// secretRotationPolicy returns the rotation policy chi requests for secretName, defaulting to
+// SecretRotationRestart when the CHI doesn't specify one.
+func secretRotationPolicy(chi *api.ClickHouseInstallation, secretName string) string {
+	switch chi.GetAnnotations()[secretRotationPolicyAnnotationPrefix+secretName] {
+	case SecretRotationReload:
+		return SecretRotationReload
+	case SecretRotationNone:
+		return SecretRotationNone
+	default:
+		return SecretRotationRestart
+	}
+}
+
+// secretRotationTracker remembers the most recently applied content hash per Secret, so
+// reconcileHostStatefulSet can stamp secretPodHashAnnotationPrefix+name onto a host's pod
+// template and pick up a rolling restart the next time that host is reconciled.
+type secretRotationTracker struct {
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+func newSecretRotationTracker() *secretRotationTracker {
+	return &secretRotationTracker{hashes: make(map[string]string)}
+}
+
+func (t *secretRotationTracker) record(namespace, name, hash string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hashes[namespace+"/"+name] = hash
+}
+
+// prune drops every tracked hash in namespace whose Secret name isn't in keep, so a Secret that
+// stops existing (deleted, renamed, CHI's cluster removed) doesn't linger in the tracker forever.
+func (t *secretRotationTracker) prune(namespace string, keep map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prefix := namespace + "/"
+	for key := range t.hashes {
+		name, ok := strippedPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if !keep[name] {
+			delete(t.hashes, key)
+		}
+	}
+}
+
+// podAnnotations returns the secret-hash/<name> annotations that should be present on a host's
+// pod template, one per Secret this tracker currently holds for namespace.
+func (t *secretRotationTracker) podAnnotations(namespace string) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make(map[string]string, len(t.hashes))
+	prefix := namespace + "/"
+	for key, hash := range t.hashes {
+		if name, ok := strippedPrefix(key, prefix); ok {
+			out[secretPodHashAnnotationPrefix+name] = hash
+		}
+	}
+	return out
+}
+
+func strippedPrefix(s, prefix string) (string, bool) {
+	if len(s) <= len(prefix) || s[:len(prefix)] != prefix {
+		return "", false
+	}
+	return s[len(prefix):], true
+}
+
+// secretRotationTracker lazily returns the worker's secretRotationTracker, creating it on first
+// use - same pattern as restartTracker.
+func (w *worker) secretRotationTracker() *secretRotationTracker {
+	if w.secretRotations == nil {
+		w.secretRotations = newSecretRotationTracker()
+	}
+	return w.secretRotations
+}
+
+// stampSecretHashAnnotations writes secret-hash/<name> annotations, one per Secret this tracker
+// currently holds for host's namespace, onto host's desired StatefulSet pod template. Kubernetes
+// treats a pod template annotation change like any other template diff, so this is what turns a
+// Secret rotation into a rolling restart of the hosts that mount it.
+func (w *worker) stampSecretHashAnnotations(host *api.Host) {
+	annotations := w.secretRotationTracker().podAnnotations(host.GetCR().Namespace)
+	if len(annotations) == 0 {
+		return
+	}
+
+	template := &host.Runtime.DesiredStatefulSet.Spec.Template
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		template.Annotations[k] = v
+	}
+}
+
+// refreshSecretHash keeps the tracker current for secret even when reconcileSecret observed no
+// content change, so the tracker reflects every restart-policy Secret the operator reconciles
+// rather than only ones it has seen rotate since this worker started. Without this, restarting the operator wipes
+// the tracker; the very next reconcile of an unchanged Secret would then return early without
+// recording anything, stampSecretHashAnnotations would stop stamping that Secret's
+// secret-hash/<name> annotation, and the resulting diff against the live StatefulSet (which still
+// carries the annotation from before the restart) would trigger a spurious rolling restart of
+// every host mounting it.
+func (w *worker) refreshSecretHash(chi *api.ClickHouseInstallation, secret *core.Secret, hash string) {
+	if secretRotationPolicy(chi, secret.Name) == SecretRotationRestart {
+		w.secretRotationTracker().record(secret.Namespace, secret.Name, hash)
+	}
+}
+
+// onSecretRotated applies secret's rotation policy once its content hash has actually changed:
+// SecretRotationRestart records the new hash so the next StatefulSet reconcile rolls the pods
+// that mount it; SecretRotationReload leaves the pods alone, relying on ClickHouse picking the
+// new file content up via SYSTEM RELOAD CONFIG; SecretRotationNone does nothing further.
+func (w *worker) onSecretRotated(chi *api.ClickHouseInstallation, secret *core.Secret, hash string) {
+	switch secretRotationPolicy(chi, secret.Name) {
+	case SecretRotationReload:
+		log.V(1).Info("Secret %s/%s rotated, relying on SYSTEM RELOAD CONFIG instead of a restart", secret.Namespace, secret.Name)
+	case SecretRotationNone:
+		log.V(1).Info("Secret %s/%s rotated, rotation policy is none - no pod action taken", secret.Namespace, secret.Name)
+	default:
+		w.secretRotationTracker().record(secret.Namespace, secret.Name, hash)
+		log.V(1).Info("Secret %s/%s rotated, hosts mounting it will roll on next reconcile", secret.Namespace, secret.Name)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"context"
+	"encoding/json"
+
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/altinity/clickhouse-operator/pkg/chop"
+)
+
+// defaultFieldManager is used when chop.Config doesn't specify one, so SSA is always attributed
+// to a stable, recognizable manager instead of falling back to the client-go default.
+const defaultFieldManager = "clickhouse-operator"
+
+// ssaKindConfigMap, etc. name the kinds chop.Config().Reconcile.ServerSideApply.DisabledKinds can
+// list to opt a resource kind out of Server-Side Apply, e.g. for clusters whose webhooks don't
+// tolerate Force-owned fields on that kind.
+const (
+	ssaKindConfigMap = "ConfigMap"
+	ssaKindService   = "Service"
+	ssaKindSecret    = "Secret"
+	ssaKindPDB       = "PodDisruptionBudget"
+)
+
+// ssaEnabledFor reports whether Server-Side Apply should be used for kind, per chop.Config.
+func ssaEnabledFor(kind string) bool {
+	for _, disabled := range chop.Config().Reconcile.ServerSideApply.DisabledKinds {
+		if disabled == kind {
+			return false
+		}
+	}
+	return true
+}
+
+// ssaPatchOptions builds the PatchOptions every apply* helper below shares: the configured (or
+// default) field manager, and Force as set in chop.Config().Reconcile.ServerSideApply.Force, which
+// decides whether the operator takes over ownership conflicts on the fields it manages.
+func ssaPatchOptions() metav1.PatchOptions {
+	fieldManager := chop.Config().Reconcile.ServerSideApply.FieldManager
+	if fieldManager == "" {
+		fieldManager = defaultFieldManager
+	}
+	force := chop.Config().Reconcile.ServerSideApply.Force
+	return metav1.PatchOptions{FieldManager: fieldManager, Force: &force}
+}
+
+// ssaApplyBody converts obj to unstructured and marshals it into an Apply patch body: apiVersion
+// and kind are stamped explicitly (types.ApplyPatchType rejects a body without them, and a typed
+// client object's TypeMeta is normally left blank), and server-managed metadata obj never actually
+// owns - resourceVersion, uid, generation, creationTimestamp, managedFields, and the whole status
+// subresource - is stripped so Force doesn't re-assert ownership of fields the operator doesn't
+// actually set.
+func ssaApplyBody(obj runtime.Object, apiVersion, kind string) ([]byte, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(u, "status")
+	if metadata, ok := u["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+		delete(metadata, "resourceVersion")
+		delete(metadata, "uid")
+		delete(metadata, "generation")
+		delete(metadata, "managedFields")
+	}
+	u["apiVersion"] = apiVersion
+	u["kind"] = kind
+
+	return json.Marshal(u)
+}
+
+// applyConfigMap server-side-applies configMap, creating it on first apply and merging operator-
+// owned fields on every subsequent one - no more copying ResourceVersion by hand.
+func (w *worker) applyConfigMap(ctx context.Context, configMap *core.ConfigMap) (*core.ConfigMap, error) {
+	data, err := ssaApplyBody(configMap, "v1", "ConfigMap")
+	if err != nil {
+		return nil, err
+	}
+	return w.c.kubeClient.CoreV1().ConfigMaps(configMap.Namespace).Patch(
+		ctx, configMap.Name, types.ApplyPatchType, data, ssaPatchOptions(),
+	)
+}
+
+// applyService server-side-applies service, which is what lets reconcileService drop its
+// delete-then-recreate fallback: fields owned by other controllers (HPA, external-dns) survive.
+func (w *worker) applyService(ctx context.Context, service *core.Service) (*core.Service, error) {
+	data, err := ssaApplyBody(service, "v1", "Service")
+	if err != nil {
+		return nil, err
+	}
+	return w.c.kubeClient.CoreV1().Services(service.Namespace).Patch(
+		ctx, service.Name, types.ApplyPatchType, data, ssaPatchOptions(),
+	)
+}
+
+// applySecret server-side-applies secret.
+func (w *worker) applySecret(ctx context.Context, secret *core.Secret) (*core.Secret, error) {
+	data, err := ssaApplyBody(secret, "v1", "Secret")
+	if err != nil {
+		return nil, err
+	}
+	return w.c.kubeClient.CoreV1().Secrets(secret.Namespace).Patch(
+		ctx, secret.Name, types.ApplyPatchType, data, ssaPatchOptions(),
+	)
+}
+
+// applyPDB server-side-applies pdb. reconcilePDB uses it in place of its get-then-update path
+// whenever Server-Side Apply is enabled for PodDisruptionBudgets; the get-then-update path, still
+// used when it is disabled, logs a failed update and returns nil.
+func (w *worker) applyPDB(ctx context.Context, pdb *policy.PodDisruptionBudget) (*policy.PodDisruptionBudget, error) {
+	data, err := ssaApplyBody(pdb, "policy/v1", "PodDisruptionBudget")
+	if err != nil {
+		return nil, err
+	}
+	return w.c.kubeClient.PolicyV1().PodDisruptionBudgets(pdb.Namespace).Patch(
+		ctx, pdb.Name, types.ApplyPatchType, data, ssaPatchOptions(),
+	)
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chi
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/altinity/clickhouse-operator/pkg/announcer"
+	"github.com/altinity/clickhouse-operator/pkg/chop"
+	"github.com/altinity/clickhouse-operator/pkg/controller/chi/metrics"
+)
+
+// defaultShutdownGracePeriod bounds how long Stop waits for in-flight shard goroutines to drain
+// before giving up and reporting them as aborted.
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// workerLifecycle holds the sync.Once-guarded start/stop state for a worker. It is embedded by
+// value into worker so zero-value workers are already safe to Start/Stop.
+type workerLifecycle struct {
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stopped   chan struct{}
+	shardWG   sync.WaitGroup
+
+	inflight int64
+
+	abortedMu     sync.Mutex
+	abortedShards []string
+}
+
+// Start arms the worker for reconciliation. Calling Start more than once is a no-op; it only
+// ever (re-)creates the stopped channel on the first call. Start does not by itself cause Stop to
+// be called - see Run for wiring Stop to a shutdown signal.
+func (w *worker) Start() {
+	w.startOnce.Do(func() {
+		w.stopped = make(chan struct{})
+	})
+}
+
+// Run is the worker's top-level entry point: arms the worker via Start, then blocks until ctx -
+// the controller's process-lifetime context, cancelled once on shutdown (SIGTERM), NOT a
+// per-reconcile context - is done, and calls Stop to drain any shard goroutines still in flight.
+// The controller's startup path is expected to call Run once, in its own goroutine, right after
+// constructing the worker. A per-reconcile context must never be passed here: it is cancelled the
+// moment that one reconcile finishes, which would call Stop after the very first reconcile and
+// make every later reconcileShardsAndHosts bail out with "worker is stopping".
+func (w *worker) Run(ctx context.Context) {
+	w.Start()
+	<-ctx.Done()
+	w.Stop()
+}
+
+// Stop signals shard goroutines that have not yet begun their reconcile to skip it and stops the
+// fan-out loop from admitting new batches, waits up to grace for the shard WaitGroup to drain,
+// and logs the shards that were skipped this way. Shards still running when grace expires are
+// not named in that log. Calling Stop more than once is a no-op.
+func (w *worker) Stop() {
+	w.stopOnce.Do(func() {
+		if w.stopped == nil {
+			// Stop called without a matching Start - nothing to drain.
+			return
+		}
+		close(w.stopped)
+
+		grace := w.shutdownGracePeriod()
+		drained := make(chan struct{})
+		go func() {
+			w.shardWG.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+			if aborted := w.takeAbortedShards(); len(aborted) > 0 {
+				log.Warning("worker stopped, all shard goroutines drained, aborted shards: %v", aborted)
+			} else {
+				log.V(1).Info("worker stopped, all shard goroutines drained")
+			}
+		case <-time.After(grace):
+			aborted := w.takeAbortedShards()
+			log.Warning("worker stop grace period (%s) expired, aborted shards: %v", grace, aborted)
+		}
+	})
+}
+
+// isStopping reports whether Stop has been called, so the shard fan-out loop can stop admitting
+// new shard goroutines.
+func (w *worker) isStopping() bool {
+	if w.stopped == nil {
+		return false
+	}
+	select {
+	case <-w.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdownGracePeriod returns the configured grace period, falling back to a sane default.
+func (w *worker) shutdownGracePeriod() time.Duration {
+	if grace := chop.Config().Reconcile.Runtime.ShutdownGracePeriod; grace > 0 {
+		return grace
+	}
+	return defaultShutdownGracePeriod
+}
+
+// beginShard admits one more shard goroutine, incrementing both the shard WaitGroup and the
+// clickhouse_operator_worker_inflight_reconciles gauge.
+func (w *worker) beginShard(name string) {
+	w.shardWG.Add(1)
+	atomic.AddInt64(&w.inflight, 1)
+	metrics.WorkerInflightReconciles(atomic.LoadInt64(&w.inflight))
+}
+
+// endShard is the matching Done for beginShard, recording name as aborted when aborted is true.
+func (w *worker) endShard(name string, aborted bool) {
+	if aborted {
+		w.abortedMu.Lock()
+		w.abortedShards = append(w.abortedShards, name)
+		w.abortedMu.Unlock()
+	}
+	atomic.AddInt64(&w.inflight, -1)
+	metrics.WorkerInflightReconciles(atomic.LoadInt64(&w.inflight))
+	w.shardWG.Done()
+}
+
+func (w *worker) takeAbortedShards() []string {
+	w.abortedMu.Lock()
+	defer w.abortedMu.Unlock()
+	aborted := w.abortedShards
+	w.abortedShards = nil
+	return aborted
+}
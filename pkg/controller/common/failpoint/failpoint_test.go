@@ -0,0 +1,126 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build failpoint
+
+package failpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEnableDisable(t *testing.T) {
+	const name = "test/enableDisable"
+	defer Disable(name)
+
+	if _, ok := lookup(name); ok {
+		t.Fatalf("lookup(%q) found a term before Enable was called", name)
+	}
+
+	Enable(name, "return(boom)")
+	term, ok := lookup(name)
+	if !ok {
+		t.Fatalf("lookup(%q) found nothing after Enable", name)
+	}
+	if term.action != "return" || term.arg != "boom" {
+		t.Fatalf("lookup(%q) = %+v, want action=return arg=boom", name, term)
+	}
+
+	Disable(name)
+	if _, ok := lookup(name); ok {
+		t.Fatalf("lookup(%q) still found a term after Disable", name)
+	}
+}
+
+func TestInjectReturn(t *testing.T) {
+	const name = "test/injectReturn"
+	Enable(name, "return(injected failure)")
+	defer Disable(name)
+
+	err := Inject(context.Background(), name)
+	if err == nil {
+		t.Fatalf("Inject(%q) = nil, want an error", name)
+	}
+	if err.Error() != "injected failure" {
+		t.Fatalf("Inject(%q) = %q, want %q", name, err.Error(), "injected failure")
+	}
+}
+
+func TestInjectUnarmedIsNoop(t *testing.T) {
+	const name = "test/injectUnarmed"
+	if err := Inject(context.Background(), name); err != nil {
+		t.Fatalf("Inject(%q) = %v, want nil for an unarmed point", name, err)
+	}
+}
+
+func TestInjectContinueIsNoop(t *testing.T) {
+	const name = "test/injectContinue"
+	Enable(name, "continue")
+	defer Disable(name)
+
+	if err := Inject(context.Background(), name); err != nil {
+		t.Fatalf("Inject(%q) = %v, want nil for a continue term", name, err)
+	}
+}
+
+func TestInjectSleepHonorsContextCancellation(t *testing.T) {
+	const name = "test/injectSleep"
+	Enable(name, "sleep(1h)")
+	defer Disable(name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- Inject(ctx, name) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Inject(%q) = %v, want nil once ctx is done", name, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Inject(%q) did not return promptly after ctx was cancelled", name)
+	}
+}
+
+func TestInjectPanics(t *testing.T) {
+	const name = "test/injectPanic"
+	Enable(name, "panic")
+	defer Disable(name)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Inject(%q) did not panic", name)
+		}
+	}()
+	_ = Inject(context.Background(), name)
+}
+
+func TestList(t *testing.T) {
+	const name = "test/list"
+	Enable(name, "return(listed)")
+	defer Disable(name)
+
+	list := List()
+	got, ok := list[name]
+	if !ok {
+		t.Fatalf("List() missing entry for %q: %v", name, list)
+	}
+	if want := "return(listed)"; got != want {
+		t.Fatalf("List()[%q] = %q, want %q", name, got, want)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build failpoint
+
+package failpoint
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Inject evaluates the named injection point if it has been armed via Enable or FAILPOINTS.
+// A "return(msg)" term makes the call site return an error; "sleep(duration)" blocks until the
+// duration elapses or ctx is done; "panic" panics; "continue" (or an unarmed point) is a no-op.
+func Inject(ctx context.Context, name string) error {
+	t, ok := lookup(name)
+	if !ok {
+		return nil
+	}
+
+	switch t.action {
+	case "return":
+		return errors.New(t.arg)
+	case "sleep":
+		if d, err := time.ParseDuration(t.arg); err == nil {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+			}
+		}
+	case "panic":
+		panic("failpoint: " + name)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !failpoint
+
+package failpoint
+
+import (
+	"context"
+	"testing"
+)
+
+// TestInjectCompiledOutIgnoresArmedPoints confirms a production build (no -tags failpoint) never
+// evaluates the registry, even for a point some test or chaos tooling armed earlier in the
+// process - Inject must always be a no-op here regardless of what's enabled.
+func TestInjectCompiledOutIgnoresArmedPoints(t *testing.T) {
+	const name = "test/compiledOut"
+	Enable(name, "return(should never fire)")
+	defer Disable(name)
+
+	if err := Inject(context.Background(), name); err != nil {
+		t.Fatalf("Inject(%q) = %v, want nil in a non-failpoint build", name, err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package failpoint provides named fault-injection points throughout the reconcile pipeline,
+// in the spirit of pingcap/failpoint. Each point supports a "return(err)", "sleep(duration)",
+// "panic", or "continue" term, armed at runtime via Enable or the FAILPOINTS environment
+// variable, so the test suite and chaos experiments can exercise partial-failure paths
+// deterministically. The evaluation itself lives behind a build tag (see inject.go /
+// inject_disabled.go) so production binaries compile the hooks out entirely.
+package failpoint
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+type term struct {
+	action string
+	arg    string
+}
+
+var (
+	mu     sync.RWMutex
+	points = map[string]term{}
+)
+
+func init() {
+	// FAILPOINTS=name=action(arg);name2=action2(arg2)
+	raw := os.Getenv("FAILPOINTS")
+	if raw == "" {
+		return
+	}
+	for _, entry := range strings.Split(raw, ";") {
+		name, t, found := strings.Cut(entry, "=")
+		if !found {
+			continue
+		}
+		Enable(name, t)
+	}
+}
+
+// Enable arms name with t, e.g. Enable("chi/afterFirstShard", "return(fan-out failure)").
+func Enable(name, t string) {
+	action, arg, _ := strings.Cut(t, "(")
+	action = strings.TrimSpace(action)
+	arg = strings.TrimSuffix(arg, ")")
+
+	mu.Lock()
+	defer mu.Unlock()
+	points[name] = term{action: action, arg: arg}
+}
+
+// Disable removes any armed term for name.
+func Disable(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(points, name)
+}
+
+// List returns the currently armed failpoints and their terms, for the admin HTTP endpoint.
+func List() map[string]string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	out := make(map[string]string, len(points))
+	for name, t := range points {
+		out[name] = fmt.Sprintf("%s(%s)", t.action, t.arg)
+	}
+	return out
+}
+
+func lookup(name string) (term, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := points[name]
+	return t, ok
+}
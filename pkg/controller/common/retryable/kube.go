@@ -0,0 +1,130 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retryable
+
+import (
+	"context"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+
+	"github.com/altinity/clickhouse-operator/pkg/interfaces"
+)
+
+// retryableKube wraps an interfaces.IKube, giving STS/ConfigMap/Service/PDB callers uniform
+// retry+jittered backoff without having to change every call site individually. Every other
+// accessor is promoted straight through from the embedded interface. ctx is the caller's
+// reconcile-scoped context: it is threaded through to every wrapped Get so a cancelled reconcile
+// (worker shutdown, timeout) aborts the retry loop's backoff sleep instead of spinning against a
+// context.Background() that never expires.
+type retryableKube struct {
+	interfaces.IKube
+	ctx         context.Context
+	retryPolicy RetryPolicy
+	classify    ClassifyFunc
+}
+
+// NewRetryableKubeClient wraps inner so that STS(), ConfigMap(), Service(), and PDB() calls made
+// through the returned client retry transient failures per retryPolicy/classify. ctx is carried
+// into every retry loop's backoff wait, so cancelling it (reconcile timeout, worker shutdown)
+// stops the retries promptly instead of waiting out context.Background().
+func NewRetryableKubeClient(ctx context.Context, inner interfaces.IKube, retryPolicy RetryPolicy, classify ClassifyFunc) interfaces.IKube {
+	return &retryableKube{IKube: inner, ctx: ctx, retryPolicy: retryPolicy, classify: classify}
+}
+
+func (k *retryableKube) STS() interfaces.IKubeSTS {
+	return &retryableSTS{IKubeSTS: k.IKube.STS(), ctx: k.ctx, retryPolicy: k.retryPolicy, classify: k.classify}
+}
+
+func (k *retryableKube) ConfigMap() interfaces.IKubeConfigMap {
+	return &retryableConfigMap{IKubeConfigMap: k.IKube.ConfigMap(), ctx: k.ctx, retryPolicy: k.retryPolicy, classify: k.classify}
+}
+
+func (k *retryableKube) Service() interfaces.IKubeService {
+	return &retryableService{IKubeService: k.IKube.Service(), ctx: k.ctx, retryPolicy: k.retryPolicy, classify: k.classify}
+}
+
+func (k *retryableKube) PDB() interfaces.IKubePDB {
+	return &retryablePDB{IKubePDB: k.IKube.PDB(), ctx: k.ctx, retryPolicy: k.retryPolicy, classify: k.classify}
+}
+
+type retryableSTS struct {
+	interfaces.IKubeSTS
+	ctx         context.Context
+	retryPolicy RetryPolicy
+	classify    ClassifyFunc
+}
+
+func (r *retryableSTS) Get(params ...interface{}) (*apps.StatefulSet, error) {
+	var sts *apps.StatefulSet
+	err := Do(r.ctx, r.retryPolicy, r.classify, "get", "statefulset", func() error {
+		var e error
+		sts, e = r.IKubeSTS.Get(params...)
+		return e
+	})
+	return sts, err
+}
+
+type retryableConfigMap struct {
+	interfaces.IKubeConfigMap
+	ctx         context.Context
+	retryPolicy RetryPolicy
+	classify    ClassifyFunc
+}
+
+func (r *retryableConfigMap) Get(params ...interface{}) (*core.ConfigMap, error) {
+	var cm *core.ConfigMap
+	err := Do(r.ctx, r.retryPolicy, r.classify, "get", "configmap", func() error {
+		var e error
+		cm, e = r.IKubeConfigMap.Get(params...)
+		return e
+	})
+	return cm, err
+}
+
+type retryableService struct {
+	interfaces.IKubeService
+	ctx         context.Context
+	retryPolicy RetryPolicy
+	classify    ClassifyFunc
+}
+
+func (r *retryableService) Get(params ...interface{}) (*core.Service, error) {
+	var svc *core.Service
+	err := Do(r.ctx, r.retryPolicy, r.classify, "get", "service", func() error {
+		var e error
+		svc, e = r.IKubeService.Get(params...)
+		return e
+	})
+	return svc, err
+}
+
+type retryablePDB struct {
+	interfaces.IKubePDB
+	ctx         context.Context
+	retryPolicy RetryPolicy
+	classify    ClassifyFunc
+}
+
+func (r *retryablePDB) Get(params ...interface{}) (*policyv1.PodDisruptionBudget, error) {
+	var pdb *policyv1.PodDisruptionBudget
+	err := Do(r.ctx, r.retryPolicy, r.classify, "get", "poddisruptionbudget", func() error {
+		var e error
+		pdb, e = r.IKubePDB.Get(params...)
+		return e
+	})
+	return pdb, err
+}
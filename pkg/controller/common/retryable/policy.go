@@ -0,0 +1,93 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retryable wraps Kubernetes and ClickHouse schemer clients with a uniform
+// retry-with-backoff layer, so call sites no longer need their own bespoke poller.PollHost loops
+// just to ride out a transient 5xx or a momentarily overloaded ClickHouse server.
+package retryable
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// RetryPolicy describes how a retryable call backs off between attempts.
+type RetryPolicy struct {
+	// InitialInterval is the delay before the first retry.
+	InitialInterval time.Duration
+	// BackoffCoefficient multiplies the interval after each failed attempt.
+	BackoffCoefficient float64
+	// MaxInterval caps the delay between retries, however many attempts have been made.
+	MaxInterval time.Duration
+	// MaxAttempts caps the number of attempts, including the first one. Zero means unlimited.
+	MaxAttempts int
+	// Expiration is the wall-clock budget for all attempts combined. Zero means unlimited.
+	Expiration time.Duration
+}
+
+// DefaultRetryPolicy is a conservative default for apiserver/ClickHouse calls: a handful of
+// attempts spread across a few seconds, capped well under a typical reconcile's own deadline.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialInterval:    200 * time.Millisecond,
+	BackoffCoefficient: 2.0,
+	MaxInterval:        5 * time.Second,
+	MaxAttempts:        5,
+	Expiration:         30 * time.Second,
+}
+
+// ClassifyFunc decides whether err is transient and therefore worth retrying.
+type ClassifyFunc func(ctx context.Context, err error) bool
+
+// DefaultClassify retries apiserver errors that are typically transient (server timeouts,
+// rate-limiting, internal errors), a context deadline that fired on a sub-call while the parent
+// context is still alive, and the handful of ClickHouse-side errors that mean "try again soon"
+// rather than "this is broken".
+func DefaultClassify(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch {
+	case apiErrors.IsServerTimeout(err),
+		apiErrors.IsTooManyRequests(err),
+		apiErrors.IsInternalError(err),
+		apiErrors.IsTimeout(err):
+		return true
+	}
+
+	if err == context.DeadlineExceeded && ctx != nil && ctx.Err() == nil {
+		// The sub-call's own deadline fired, but the caller's context is still alive -
+		// worth another attempt rather than propagating a spurious failure.
+		return true
+	}
+
+	return isTransientClickHouseError(err)
+}
+
+// isTransientClickHouseError matches the handful of ClickHouse error strings that indicate the
+// server is momentarily unavailable rather than misconfigured.
+func isTransientClickHouseError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "server is overloaded"),
+		strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "too many simultaneous queries"),
+		strings.Contains(msg, "i/o timeout"):
+		return true
+	}
+	return false
+}
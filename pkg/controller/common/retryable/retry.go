@@ -0,0 +1,93 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retryable
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/altinity/clickhouse-operator/pkg/controller/chi/metrics"
+)
+
+// Do runs fn, retrying with jittered exponential backoff per policy as long as classify reports
+// the error as transient. op/resource identify the call for the
+// clickhouse_operator_retries_total{op,resource,reason} counter, so users can see which
+// transient errors are actually happening without turning on verbose logging.
+func Do(ctx context.Context, policy RetryPolicy, classify ClassifyFunc, op, resource string, fn func() error) error {
+	if classify == nil {
+		classify = DefaultClassify
+	}
+
+	interval := policy.InitialInterval
+	if interval <= 0 {
+		interval = 200 * time.Millisecond
+	}
+
+	var deadline time.Time
+	if policy.Expiration > 0 {
+		deadline = time.Now().Add(policy.Expiration)
+	}
+
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		lastErr = fn()
+		if lastErr == nil {
+			return nil
+		}
+		if !classify(ctx, lastErr) {
+			return lastErr
+		}
+
+		metrics.RetriesTotal(ctx, op, resource, reason(lastErr))
+
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return lastErr
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return lastErr
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jitter(interval)):
+		}
+
+		interval = time.Duration(float64(interval) * policy.BackoffCoefficient)
+		if policy.MaxInterval > 0 && interval > policy.MaxInterval {
+			interval = policy.MaxInterval
+		}
+	}
+}
+
+// jitter randomizes interval by +/-20% so concurrently retrying workers don't thunder in lockstep.
+func jitter(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	delta := float64(interval) * 0.2
+	return interval + time.Duration(rand.Float64()*2*delta-delta)
+}
+
+// reason classifies lastErr into a small, stable label cardinality for the retries metric.
+func reason(err error) string {
+	switch {
+	case isTransientClickHouseError(err):
+		return "clickhouse-transient"
+	default:
+		return "apiserver-transient"
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Altinity Ltd and/or its affiliates. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retryable
+
+import (
+	"context"
+
+	api "github.com/altinity/clickhouse-operator/pkg/apis/clickhouse.altinity.com/v1"
+)
+
+// Schemer is the subset of the ClickHouse schema-management client that benefits from retrying
+// transient server errors - today just the version probe used to gate reconcile progress.
+type Schemer interface {
+	HostClickHouseVersion(ctx context.Context, host *api.Host) (string, error)
+}
+
+type retryableSchemer struct {
+	inner       Schemer
+	retryPolicy RetryPolicy
+	classify    ClassifyFunc
+}
+
+// NewRetryableSchemer wraps inner so HostClickHouseVersion retries transient ClickHouse errors
+// (server overloaded, connection refused, ...) per retryPolicy/classify instead of callers having
+// to build their own poller.PollHost loop around it.
+func NewRetryableSchemer(inner Schemer, retryPolicy RetryPolicy, classify ClassifyFunc) Schemer {
+	return &retryableSchemer{inner: inner, retryPolicy: retryPolicy, classify: classify}
+}
+
+func (r *retryableSchemer) HostClickHouseVersion(ctx context.Context, host *api.Host) (string, error) {
+	var version string
+	err := Do(ctx, r.retryPolicy, r.classify, "version", "clickhouse", func() error {
+		var e error
+		version, e = r.inner.HostClickHouseVersion(ctx, host)
+		return e
+	})
+	return version, err
+}